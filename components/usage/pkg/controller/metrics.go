@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "gitpod"
+const metricsSubsystem = "usage"
+
+// reconcilerMetrics bundles the Prometheus collectors the UsageReconciler
+// reports reconciliation health and billing-relevant numbers through.
+type reconcilerMetrics struct {
+	instancesReconciled          *prometheus.CounterVec
+	invalidInstances             prometheus.Counter
+	creditsPerTeam               prometheus.Histogram
+	lastReconcileTime            prometheus.Gauge
+	lastReconcileDurationSeconds prometheus.Gauge
+}
+
+func newReconcilerMetrics() *reconcilerMetrics {
+	return &reconcilerMetrics{
+		instancesReconciled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "reconciler_workspace_instances_total",
+			Help:      "Total number of workspace instances reconciled, labelled by workspace class and type",
+		}, []string{"workspace_class", "workspace_type"}),
+		invalidInstances: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "reconciler_invalid_workspace_instances_total",
+			Help:      "Total number of workspace instances skipped during reconciliation because they were missing required fields",
+		}),
+		creditsPerTeam: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "reconciler_credits_per_team",
+			Help:      "Distribution of credits emitted per team in a single reconciliation run",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+		}),
+		lastReconcileTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "reconciler_last_successful_reconcile_time",
+			Help:      "Unix timestamp of the last successful reconciliation run",
+		}),
+		lastReconcileDurationSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "reconciler_last_reconcile_duration_seconds",
+			Help:      "Duration of the last reconciliation run, in seconds",
+		}),
+	}
+}
+
+// Register registers all collectors with reg. It must only be called once
+// per reconcilerMetrics instance.
+func (m *reconcilerMetrics) Register(reg prometheus.Registerer) error {
+	collectors := []prometheus.Collector{
+		m.instancesReconciled,
+		m.invalidInstances,
+		m.creditsPerTeam,
+		m.lastReconcileTime,
+		m.lastReconcileDurationSeconds,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package controller
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+	"gorm.io/gorm"
+)
+
+// defaultWorkspaceClass is used whenever a workspace instance's class is not
+// present in a pricer's rate table.
+const defaultWorkspaceClass = "default"
+
+// WorkspacePricer turns the time a workspace instance of a given class,
+// billed to attributionID, ran for within [start, end) into the number of
+// credits that usage is billed as.
+type WorkspacePricer interface {
+	CreditsUsed(class string, attributionID db.AttributionID, start, end time.Time) int64
+}
+
+// priceChangeBoundaryPricer is implemented by pricers whose rate can change
+// partway through an interval. instancesToUsageRecords uses it to split an
+// instance's billed time along those boundaries, rather than asking the
+// pricer to average a changing rate over the whole interval itself.
+type priceChangeBoundaryPricer interface {
+	// PriceChangeBoundaries returns every point within (start, end) at which
+	// the pricer's rate changes.
+	PriceChangeBoundaries(start, end time.Time) []time.Time
+}
+
+// StaticPricer is a flat class-to-credits-per-minute rate table, the same
+// pricing for every team, at every point in time.
+type StaticPricer struct {
+	// WorkspaceClassCreditsPerMinute maps a workspace class to the number of
+	// credits charged per minute of usage. defaultWorkspaceClass must always
+	// be present and is used as a fallback for unknown classes.
+	WorkspaceClassCreditsPerMinute map[string]float64
+}
+
+func (p StaticPricer) CreditsUsed(class string, _ db.AttributionID, start, end time.Time) int64 {
+	return int64(end.Sub(start).Minutes() * p.rate(class))
+}
+
+func (p StaticPricer) rate(class string) float64 {
+	rate, ok := p.WorkspaceClassCreditsPerMinute[class]
+	if !ok {
+		rate = p.WorkspaceClassCreditsPerMinute[defaultWorkspaceClass]
+	}
+	return rate
+}
+
+// DefaultWorkspacePricer is the rate table used in production today: a flat
+// 10 credits per hour, regardless of workspace class.
+var DefaultWorkspacePricer WorkspacePricer = StaticPricer{
+	WorkspaceClassCreditsPerMinute: map[string]float64{
+		defaultWorkspaceClass: 10.0 / 60.0,
+	},
+}
+
+// PriceChangePoint is a rate table that becomes effective at EffectiveFrom,
+// and remains so until the next PriceChangePoint in a ScheduledPricer's
+// Schedule (or forever, for the last one).
+type PriceChangePoint struct {
+	EffectiveFrom                  time.Time
+	WorkspaceClassCreditsPerMinute map[string]float64
+}
+
+// ScheduledPricer charges different rates at different points in time, e.g.
+// for promotions or quiet-hours discounts. A workspace instance that ran
+// across a price change is billed pro-rata: instancesToUsageRecords splits
+// its billed time at each PriceChangePoint via PriceChangeBoundaries.
+type ScheduledPricer struct {
+	// Schedule must be sorted by EffectiveFrom ascending.
+	Schedule []PriceChangePoint
+}
+
+func (p ScheduledPricer) CreditsUsed(class string, _ db.AttributionID, start, end time.Time) int64 {
+	return int64(end.Sub(start).Minutes() * p.rateAt(class, start))
+}
+
+func (p ScheduledPricer) PriceChangeBoundaries(start, end time.Time) []time.Time {
+	var boundaries []time.Time
+	for _, point := range p.Schedule {
+		if point.EffectiveFrom.After(start) && point.EffectiveFrom.Before(end) {
+			boundaries = append(boundaries, point.EffectiveFrom)
+		}
+	}
+	return boundaries
+}
+
+func (p ScheduledPricer) rateAt(class string, at time.Time) float64 {
+	var rates map[string]float64
+	for _, point := range p.Schedule {
+		if point.EffectiveFrom.After(at) {
+			break
+		}
+		rates = point.WorkspaceClassCreditsPerMinute
+	}
+	rate, ok := rates[class]
+	if !ok {
+		rate = rates[defaultWorkspaceClass]
+	}
+	return rate
+}
+
+// PerTeamOverridePricer charges a team-specific rate, loaded from the
+// `pricing_overrides` table, falling back to Fallback for classes or teams
+// without an override (e.g. enterprise negotiated rates).
+type PerTeamOverridePricer struct {
+	conn     *gorm.DB
+	Fallback WorkspacePricer
+}
+
+// NewPerTeamOverridePricer constructs a PerTeamOverridePricer backed by
+// conn, falling back to fallback for teams or classes without an override.
+func NewPerTeamOverridePricer(conn *gorm.DB, fallback WorkspacePricer) *PerTeamOverridePricer {
+	return &PerTeamOverridePricer{conn: conn, Fallback: fallback}
+}
+
+func (p *PerTeamOverridePricer) CreditsUsed(class string, attributionID db.AttributionID, start, end time.Time) int64 {
+	if attributionID.IsEntity(db.AttributionEntityTeam) {
+		_, teamID := attributionID.Values()
+		// Pricing lookups are single-row reads by primary key, so
+		// WorkspacePricer doesn't thread a context through; each lookup
+		// uses its own background context.
+		override, ok, err := db.FindPricingOverride(context.Background(), p.conn, teamID, class)
+		switch {
+		case err != nil:
+			// A failed lookup is not the same thing as "no override
+			// exists" — silently falling back here would overbill a team
+			// with a negotiated rate whenever the lookup has a transient
+			// failure, so make noise instead of masking it.
+			log.Printf("failed to look up pricing override for team %s, workspace class %s, falling back to default pricing: %v", teamID, class, err)
+		case ok:
+			return int64(end.Sub(start).Minutes() * override.CreditsPerMinute)
+		}
+	}
+	return p.Fallback.CreditsUsed(class, attributionID, start, end)
+}
+
+// PriceChangeBoundaries forwards to Fallback's boundaries, if it has any.
+// PricingOverride rates don't themselves vary over time, so the only
+// boundaries that can fall inside a billed interval are the fallback
+// pricer's; without this, wrapping a ScheduledPricer as Fallback would
+// silently lose its pro-rata splitting.
+func (p *PerTeamOverridePricer) PriceChangeBoundaries(start, end time.Time) []time.Time {
+	if bp, ok := p.Fallback.(priceChangeBoundaryPricer); ok {
+		return bp.PriceChangeBoundaries(start, end)
+	}
+	return nil
+}
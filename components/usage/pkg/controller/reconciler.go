@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/controller/rollup"
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+	"github.com/gitpod-io/gitpod/usage/pkg/db/dbtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// BillingController is notified of the usage report produced by a
+// reconciliation run so it can update any downstream billing system.
+type BillingController interface {
+	Reconcile(ctx context.Context, report UsageReport) error
+}
+
+// NoOpBillingController is a BillingController that does nothing. It is used
+// wherever billing is not configured, e.g. in tests or in self-hosted
+// installations without a billing integration.
+type NoOpBillingController struct{}
+
+func (*NoOpBillingController) Reconcile(ctx context.Context, report UsageReport) error {
+	return nil
+}
+
+// UsageReconcileStatus summarizes the outcome of a single reconciliation
+// run.
+type UsageReconcileStatus struct {
+	StartTime                 time.Time
+	EndTime                   time.Time
+	WorkspaceInstances        int
+	InvalidWorkspaceInstances int
+}
+
+// UsageReconciler recomputes workspace instance usage and credits for a
+// given time range and hands the result to a BillingController.
+type UsageReconciler struct {
+	conn              *gorm.DB
+	pricer            WorkspacePricer
+	billingController BillingController
+	nowFunc           func() time.Time
+
+	// rolluper, if set, is kept up to date with per (team, day, workspace
+	// class) credit totals as a byproduct of every reconciliation run, so
+	// that near-real-time balances don't have to wait for its own ticker.
+	rolluper *rollup.Rolluper
+
+	metrics *reconcilerMetrics
+}
+
+// NewUsageReconciler constructs a UsageReconciler and registers its
+// Prometheus metrics with reg. reg must not already have usage reconciler
+// metrics registered. rolluper may be nil, in which case reconciliation runs
+// don't update the usage rollup table.
+func NewUsageReconciler(conn *gorm.DB, pricer WorkspacePricer, billingController BillingController, rolluper *rollup.Rolluper, reg prometheus.Registerer) (*UsageReconciler, error) {
+	metrics := newReconcilerMetrics()
+	if err := metrics.Register(reg); err != nil {
+		return nil, fmt.Errorf("failed to register usage reconciler metrics: %w", err)
+	}
+
+	return &UsageReconciler{
+		conn:              conn,
+		pricer:            pricer,
+		billingController: billingController,
+		rolluper:          rolluper,
+		nowFunc:           dbtime.Now,
+		metrics:           metrics,
+	}, nil
+}
+
+// ReconcileTimeRange recomputes usage for all workspace instances whose
+// usage falls within [startTime, endTime), hands the resulting report to the
+// configured BillingController, and returns a summary of what was
+// reconciled.
+func (u *UsageReconciler) ReconcileTimeRange(ctx context.Context, startTime, endTime time.Time) (*UsageReconcileStatus, UsageReport, error) {
+	reconcileStart := u.nowFunc()
+
+	maxStopTime := endTime
+	if now := reconcileStart; now.Before(maxStopTime) {
+		maxStopTime = now
+	}
+
+	instances, err := db.FindWorkspaceInstancesForUsageInRange(ctx, u.conn, startTime, endTime)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find workspace instances for reconciliation: %w", err)
+	}
+
+	var (
+		valid        []db.WorkspaceInstanceForUsage
+		invalidCount int
+	)
+	for _, instance := range instances {
+		if !instance.CreationTime.IsSet() {
+			invalidCount++
+			continue
+		}
+		valid = append(valid, db.WorkspaceInstanceForUsage{
+			ID:                 instance.ID,
+			WorkspaceID:        instance.WorkspaceID,
+			OwnerID:            instance.OwnerID,
+			ProjectID:          instance.ProjectID,
+			WorkspaceClass:     instance.WorkspaceClass,
+			Type:               instance.Type,
+			UsageAttributionID: instance.UsageAttributionID,
+			CreationTime:       instance.CreationTime,
+			StoppedTime:        instance.StoppedTime,
+		})
+	}
+
+	report := instancesToUsageRecords(valid, u.pricer, maxStopTime)
+
+	if u.rolluper != nil {
+		if err := u.rolluper.UpsertForInstances(ctx, instances, maxStopTime); err != nil {
+			return nil, nil, fmt.Errorf("failed to update usage rollup: %w", err)
+		}
+	}
+
+	if u.metrics != nil {
+		u.metrics.invalidInstances.Add(float64(invalidCount))
+		for _, entry := range report {
+			u.metrics.instancesReconciled.WithLabelValues(entry.WorkspaceClass, string(entry.WorkspaceType)).Inc()
+		}
+	}
+
+	status := &UsageReconcileStatus{
+		StartTime:                 startTime,
+		EndTime:                   endTime,
+		WorkspaceInstances:        len(valid),
+		InvalidWorkspaceInstances: invalidCount,
+	}
+
+	if err := u.billingController.Reconcile(ctx, report); err != nil {
+		return status, report, fmt.Errorf("failed to reconcile billing for time range: %w", err)
+	}
+
+	if u.metrics != nil {
+		for _, credits := range report.CreditSummaryForTeams() {
+			u.metrics.creditsPerTeam.Observe(float64(credits))
+		}
+		u.metrics.lastReconcileTime.Set(float64(u.nowFunc().Unix()))
+		u.metrics.lastReconcileDurationSeconds.Set(u.nowFunc().Sub(reconcileStart).Seconds())
+	}
+
+	return status, report, nil
+}
+
+// instancesToUsageRecords turns raw workspace instances into billable usage
+// records, capping any still-running (or not-yet-reconciled) instance's
+// usage at maxStopTime.
+func instancesToUsageRecords(instances []db.WorkspaceInstanceForUsage, pricer WorkspacePricer, maxStopTime time.Time) []db.WorkspaceInstanceUsage {
+	var records []db.WorkspaceInstanceUsage
+	for _, instance := range instances {
+		startedAt := dbtime.FromVarchar(instance.CreationTime)
+
+		stoppedAt := dbtime.NullTimeFromVarchar(instance.StoppedTime)
+		effectiveEnd := maxStopTime
+		if stoppedAt.Valid && stoppedAt.Time.Before(maxStopTime) {
+			effectiveEnd = stoppedAt.Time
+		}
+
+		credits := creditsForInterval(pricer, instance.WorkspaceClass, instance.UsageAttributionID, startedAt, effectiveEnd)
+
+		records = append(records, db.WorkspaceInstanceUsage{
+			InstanceID:     instance.ID,
+			AttributionID:  instance.UsageAttributionID,
+			UserID:         instance.OwnerID,
+			WorkspaceID:    instance.WorkspaceID,
+			ProjectID:      instance.ProjectID.String,
+			WorkspaceType:  instance.Type,
+			WorkspaceClass: instance.WorkspaceClass,
+			CreditsUsed:    credits,
+			StartedAt:      startedAt,
+			StoppedAt:      stoppedAt,
+		})
+	}
+	return records
+}
+
+// creditsForInterval bills [start, end) at pricer's rate, splitting the
+// interval at any price-change boundaries pricer reports so that a workspace
+// running across a price change is billed pro-rata across the sub-intervals
+// either side of it.
+func creditsForInterval(pricer WorkspacePricer, class string, attributionID db.AttributionID, start, end time.Time) int64 {
+	boundaries := []time.Time{start, end}
+	if bp, ok := pricer.(priceChangeBoundaryPricer); ok {
+		boundaries = append(boundaries, bp.PriceChangeBoundaries(start, end)...)
+		sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].Before(boundaries[j]) })
+	}
+
+	var total int64
+	for i := 0; i+1 < len(boundaries); i++ {
+		total += pricer.CreditsUsed(class, attributionID, boundaries[i], boundaries[i+1])
+	}
+	return total
+}
@@ -153,6 +153,74 @@ func TestUsageReport_CreditSummaryForTeams(t *testing.T) {
 	}
 }
 
+func TestUsageReport_CreditSummaryForProjects(t *testing.T) {
+	teamID := uuid.New().String()
+	teamAttributionID := db.NewTeamAttributionID(teamID)
+	projectID := uuid.New().String()
+
+	scenarios := []struct {
+		Name             string
+		Report           UsageReport
+		ExpectedProjects map[string]int64
+		Expected         map[ProjectAttribution]int64
+	}{
+		{
+			Name:             "no instances in report, no summary",
+			Report:           []db.WorkspaceInstanceUsage{},
+			ExpectedProjects: map[string]int64{},
+			Expected:         map[ProjectAttribution]int64{},
+		},
+		{
+			Name: "instance with no project is bucketed under the sentinel",
+			Report: []db.WorkspaceInstanceUsage{
+				{
+					AttributionID: teamAttributionID,
+					WorkspaceType: db.WorkspaceType_Regular,
+					ProjectID:     "",
+					CreditsUsed:   10,
+				},
+			},
+			ExpectedProjects: map[string]int64{
+				noProjectID: 10,
+			},
+			Expected: map[ProjectAttribution]int64{
+				{TeamID: teamID, ProjectID: noProjectID, WorkspaceType: db.WorkspaceType_Regular}: 10,
+			},
+		},
+		{
+			Name: "regular workspaces and prebuilds for the same project are kept separate",
+			Report: []db.WorkspaceInstanceUsage{
+				{
+					AttributionID: teamAttributionID,
+					WorkspaceType: db.WorkspaceType_Regular,
+					ProjectID:     projectID,
+					CreditsUsed:   10,
+				},
+				{
+					AttributionID: teamAttributionID,
+					WorkspaceType: db.WorkspaceType_Prebuild,
+					ProjectID:     projectID,
+					CreditsUsed:   5,
+				},
+			},
+			ExpectedProjects: map[string]int64{
+				projectID: 15,
+			},
+			Expected: map[ProjectAttribution]int64{
+				{TeamID: teamID, ProjectID: projectID, WorkspaceType: db.WorkspaceType_Regular}:  10,
+				{TeamID: teamID, ProjectID: projectID, WorkspaceType: db.WorkspaceType_Prebuild}: 5,
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.Name, func(t *testing.T) {
+			require.Equal(t, s.Expected, s.Report.CreditSummaryByAttribution())
+			require.Equal(t, s.ExpectedProjects, s.Report.CreditSummaryForProjects())
+		})
+	}
+}
+
 func TestInstanceToUsageRecords(t *testing.T) {
 	maxStopTime := time.Date(2022, 05, 31, 23, 00, 00, 00, time.UTC)
 	teamID, ownerID, projectID := uuid.New().String(), uuid.New(), uuid.New()
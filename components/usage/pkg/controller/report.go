@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package controller
+
+import (
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+)
+
+// UsageReport is the set of usage records produced by a single reconciliation
+// run.
+type UsageReport []db.WorkspaceInstanceUsage
+
+// CreditSummaryForTeams sums up the credits used by each team in the report,
+// keyed by team ID. Usage attributed to a user rather than a team is
+// skipped.
+func (r UsageReport) CreditSummaryForTeams() map[string]int64 {
+	summary := map[string]int64{}
+	for _, entry := range r {
+		if !entry.AttributionID.IsEntity(db.AttributionEntityTeam) {
+			continue
+		}
+		_, teamID := entry.AttributionID.Values()
+		summary[teamID] += entry.CreditsUsed
+	}
+	return summary
+}
+
+// noProjectID is the sentinel ProjectID used for usage not attributed to any
+// project, so that it is aggregated visibly rather than dropped.
+const noProjectID = "no-project"
+
+// ProjectAttribution identifies the (team, project, workspace type) tuple
+// credits in CreditSummaryByAttribution are grouped by. Prebuilds are kept
+// separate from regular workspaces within the same project, since they are
+// usually billed and reasoned about differently.
+type ProjectAttribution struct {
+	TeamID        string
+	ProjectID     string
+	WorkspaceType db.WorkspaceType
+}
+
+// CreditSummaryByAttribution sums up the credits used by each
+// (team, project, workspace type) tuple in the report. Usage attributed to a
+// user rather than a team is skipped. Usage with no ProjectID is bucketed
+// under noProjectID rather than dropped.
+func (r UsageReport) CreditSummaryByAttribution() map[ProjectAttribution]int64 {
+	summary := map[ProjectAttribution]int64{}
+	for _, entry := range r {
+		if !entry.AttributionID.IsEntity(db.AttributionEntityTeam) {
+			continue
+		}
+		_, teamID := entry.AttributionID.Values()
+
+		projectID := entry.ProjectID
+		if projectID == "" {
+			projectID = noProjectID
+		}
+
+		key := ProjectAttribution{
+			TeamID:        teamID,
+			ProjectID:     projectID,
+			WorkspaceType: entry.WorkspaceType,
+		}
+		summary[key] += entry.CreditsUsed
+	}
+	return summary
+}
+
+// CreditSummaryForProjects sums up the credits used by each project across
+// all teams and workspace types, keyed by project ID. See
+// CreditSummaryByAttribution for how usage without a project is handled.
+func (r UsageReport) CreditSummaryForProjects() map[string]int64 {
+	summary := map[string]int64{}
+	for key, credits := range r.CreditSummaryByAttribution() {
+		summary[key.ProjectID] += credits
+	}
+	return summary
+}
+
+const defaultPageLimit = 50
+
+// UsageCursor selects a single page of instance-level usage entries for one
+// attribution ID.
+type UsageCursor struct {
+	AttributionID db.AttributionID
+	// Search, if set, filters entries to those whose workspace ID contains it.
+	Search string
+	// Limit is the page size. Defaults to defaultPageLimit if <= 0.
+	Limit int
+	// Page is the 1-indexed page number. Defaults to 1 if <= 0.
+	Page int
+	// Since, if set, excludes entries that started before it.
+	Since time.Time
+	// Before, if set, excludes entries that started at or after it.
+	Before time.Time
+}
+
+// UsageReportPage is a single page of instance-level usage entries, as
+// returned by UsageReporter.Page.
+type UsageReportPage struct {
+	Results     []db.WorkspaceInstanceUsage
+	PageCount   int
+	TotalCount  int64
+	CurrentPage int
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+// Package rollup maintains a per (team, day, workspace class) rollup of
+// usage credits, updated incrementally on a short cadence, so that "today's"
+// balance can be queried cheaply instead of re-scanning all workspace
+// instances for the month.
+package rollup
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+	"github.com/gitpod-io/gitpod/usage/pkg/db/dbtime"
+	"gorm.io/gorm"
+)
+
+// DefaultInterval is how often a Rolluper ticks when none is configured.
+const DefaultInterval = 5 * time.Minute
+
+// PriceFunc returns the number of credits used by a workspace of class,
+// billed to attributionID, for the time it ran within [start, end). It is
+// satisfied by controller.WorkspacePricer.CreditsUsed.
+type PriceFunc func(class string, attributionID db.AttributionID, start, end time.Time) int64
+
+// BoundaryFunc returns every point within (start, end) at which PriceFunc's
+// rate changes, so that a bucket's credits can be split pro-rata either side
+// of a price change the same way the reconciler does. It is satisfied by
+// controller.WorkspacePricer implementations that also implement
+// PriceChangeBoundaries, e.g. ScheduledPricer and PerTeamOverridePricer. A
+// nil BoundaryFunc means price is assumed time-invariant within a day.
+type BoundaryFunc func(start, end time.Time) []time.Time
+
+// Rolluper incrementally upserts WorkspaceInstanceUsageRollup rows for
+// whatever buckets were touched since its last tick.
+type Rolluper struct {
+	conn       *gorm.DB
+	price      PriceFunc
+	boundaries BoundaryFunc
+	interval   time.Duration
+	nowFunc    func() time.Time
+
+	lastTick time.Time
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewRolluper constructs a Rolluper that upserts rollup rows for instances
+// whose usage changed, every interval. If interval is zero, DefaultInterval
+// is used. boundaries may be nil, in which case price is assumed not to
+// change within a day.
+func NewRolluper(conn *gorm.DB, price PriceFunc, boundaries BoundaryFunc, interval time.Duration) *Rolluper {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Rolluper{
+		conn:       conn,
+		price:      price,
+		boundaries: boundaries,
+		interval:   interval,
+		nowFunc:    dbtime.Now,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start begins ticking on r's configured interval, aligned to the minute, in
+// a background goroutine. It returns immediately; call Close to stop.
+func (r *Rolluper) Start(ctx context.Context) {
+	r.lastTick = r.nowFunc()
+
+	go func() {
+		defer close(r.done)
+
+		timer := time.NewTimer(time.Until(r.nextTick()))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stop:
+				return
+			case <-timer.C:
+				now := r.nowFunc()
+				if err := r.tick(ctx, now); err == nil {
+					r.lastTick = now
+				}
+				timer.Reset(time.Until(r.nextTick()))
+			}
+		}
+	}()
+}
+
+// Close stops the background ticking goroutine and waits for it to exit.
+func (r *Rolluper) Close() error {
+	r.closeOnce.Do(func() { close(r.stop) })
+	<-r.done
+	return nil
+}
+
+func (r *Rolluper) nextTick() time.Time {
+	now := r.nowFunc()
+	return now.Truncate(r.interval).Add(r.interval)
+}
+
+// tick recomputes and overwrites the rollup buckets for every day touched by
+// instances whose usage changed in [r.lastTick, now). It always recomputes a
+// touched day from the complete set of instances overlapping it, rather
+// than from just the instances that triggered the tick, so that it uses the
+// same overwrite semantics as UpsertForInstances: whichever of the two runs
+// last for a given bucket, the result is the same, correctly-summed total,
+// never a partial one stacked additively on top of another.
+func (r *Rolluper) tick(ctx context.Context, now time.Time) error {
+	changed, err := db.FindWorkspaceInstancesForUsageInRange(ctx, r.conn, r.lastTick, now)
+	if err != nil {
+		return err
+	}
+
+	for _, day := range touchedDays(changed, r.lastTick, now) {
+		dayEnd := day.AddDate(0, 0, 1)
+		if now.Before(dayEnd) {
+			dayEnd = now
+		}
+
+		instances, err := db.FindWorkspaceInstancesForUsageInRange(ctx, r.conn, day, dayEnd)
+		if err != nil {
+			return err
+		}
+		if err := r.overwriteBuckets(ctx, instances, day, dayEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpsertForInstances recomputes and upserts the rollup buckets touched by
+// instances, capping still-running instances' usage at now. It is exported
+// so callers that already loaded a set of instances (e.g. the
+// UsageReconciler, during a full reconciliation run) can delegate to the
+// rollup service without it re-querying the database.
+//
+// It uses the same overwrite semantics tick does: both always recompute a
+// bucket from a complete set of instances for whatever day(s) they touch,
+// so running UpsertForInstances and a Rolluper's own ticker against the
+// same table never double-counts, regardless of which one runs last.
+func (r *Rolluper) UpsertForInstances(ctx context.Context, instances []db.WorkspaceInstance, now time.Time) error {
+	return r.overwriteBuckets(ctx, instances, time.Time{}, now)
+}
+
+type bucketKey struct {
+	attributionID  db.AttributionID
+	day            time.Time
+	workspaceClass string
+}
+
+// overwriteBuckets groups instances into their (team, day, workspace class)
+// buckets, sums credits per bucket within [windowStart, windowEnd), and
+// overwrites each one. A zero windowStart leaves an instance's interval
+// unclipped at the start, i.e. "since it was created" — UpsertForInstances
+// uses this, since its caller already loaded the complete set of instances
+// for whatever it's reconciling. Instances missing a CreationTime are
+// skipped, as they carry no usable usage information. An instance that ran
+// across a day boundary has its credits split pro-rata across each day's
+// bucket, rather than all attributed to the day it stopped on.
+func (r *Rolluper) overwriteBuckets(ctx context.Context, instances []db.WorkspaceInstance, windowStart, windowEnd time.Time) error {
+	credits := map[bucketKey]int64{}
+	for _, instance := range instances {
+		if !instance.CreationTime.IsSet() {
+			continue
+		}
+
+		start := instance.CreationTime.Time()
+		if windowStart.After(start) {
+			start = windowStart
+		}
+		end := windowEnd
+		if instance.StoppedTime.IsSet() && instance.StoppedTime.Time().Before(end) {
+			end = instance.StoppedTime.Time()
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		r.addCreditsByDay(credits, instance, start, end)
+	}
+
+	for key, total := range credits {
+		bucket := db.WorkspaceInstanceUsageRollup{
+			AttributionID:  key.attributionID,
+			Day:            key.day,
+			WorkspaceClass: key.workspaceClass,
+			CreditsUsed:    total,
+		}
+		if err := db.UpsertWorkspaceInstanceUsageRollup(ctx, r.conn, bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchedDays returns the distinct bucket days that instances' usage within
+// [windowStart, windowEnd) falls on, so tick knows which days need to be
+// fully recomputed.
+func touchedDays(instances []db.WorkspaceInstance, windowStart, windowEnd time.Time) []time.Time {
+	seen := map[time.Time]bool{}
+	var days []time.Time
+	for _, instance := range instances {
+		if !instance.CreationTime.IsSet() {
+			continue
+		}
+
+		start := instance.CreationTime.Time()
+		if windowStart.After(start) {
+			start = windowStart
+		}
+		end := windowEnd
+		if instance.StoppedTime.IsSet() && instance.StoppedTime.Time().Before(end) {
+			end = instance.StoppedTime.Time()
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		for day := bucketDay(start); !day.After(bucketDay(end.Add(-time.Nanosecond))); day = day.AddDate(0, 0, 1) {
+			if !seen[day] {
+				seen[day] = true
+				days = append(days, day)
+			}
+		}
+	}
+	return days
+}
+
+// addCreditsByDay splits [start, end) at every day boundary it crosses and
+// adds the credits for each resulting sub-interval to credits, keyed by that
+// sub-interval's own day bucket. Within a day, it further splits at any
+// price-change boundaries r.boundaries reports, the same way
+// controller.creditsForInterval does, so rollup totals agree with the
+// authoritative UsageReport under a price schedule or per-team override.
+func (r *Rolluper) addCreditsByDay(credits map[bucketKey]int64, instance db.WorkspaceInstance, start, end time.Time) {
+	for start.Before(end) {
+		dayEnd := bucketDay(start).AddDate(0, 0, 1)
+		if dayEnd.After(end) {
+			dayEnd = end
+		}
+
+		key := bucketKey{
+			attributionID:  instance.UsageAttributionID,
+			day:            bucketDay(start),
+			workspaceClass: instance.WorkspaceClass,
+		}
+		credits[key] += r.creditsForSubInterval(instance.WorkspaceClass, instance.UsageAttributionID, start, dayEnd)
+
+		start = dayEnd
+	}
+}
+
+// creditsForSubInterval bills [start, end) at r.price's rate, splitting the
+// interval at any price-change boundaries r.boundaries reports so that a
+// workspace running across a price change within a day is billed pro-rata
+// across the sub-intervals either side of it.
+func (r *Rolluper) creditsForSubInterval(class string, attributionID db.AttributionID, start, end time.Time) int64 {
+	boundaries := []time.Time{start, end}
+	if r.boundaries != nil {
+		boundaries = append(boundaries, r.boundaries(start, end)...)
+		sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].Before(boundaries[j]) })
+	}
+
+	var total int64
+	for i := 0; i+1 < len(boundaries); i++ {
+		total += r.price(class, attributionID, boundaries[i], boundaries[i+1])
+	}
+	return total
+}
+
+func bucketDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
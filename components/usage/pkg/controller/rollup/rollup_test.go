@@ -0,0 +1,150 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package rollup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+	"github.com/gitpod-io/gitpod/usage/pkg/db/dbtest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRolluper_TickConvergesAcrossTicks reproduces the scenario where two
+// instances in the same (team, day, workspace class) bucket stop a few
+// minutes apart: a tick triggered by the second instance must recompute the
+// whole day's bucket from every instance that overlaps it, not just the one
+// that triggered it, so the bucket ends up with both instances' credits
+// rather than just the latest tick's.
+func TestRolluper_TickConvergesAcrossTicks(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+
+	teamID := uuid.New().String()
+	attributionID := db.NewTeamAttributionID(teamID)
+	day := time.Date(2022, 05, 30, 0, 0, 0, 0, time.UTC)
+
+	instanceA := dbtest.NewWorkspaceInstance(t, db.WorkspaceInstance{
+		ID:                 uuid.New(),
+		UsageAttributionID: attributionID,
+		CreationTime:       db.NewVarcharTime(day.Add(10 * time.Hour)),
+		StoppedTime:        db.NewVarcharTime(day.Add(10*time.Hour + 4*time.Minute)),
+	})
+	instanceB := dbtest.NewWorkspaceInstance(t, db.WorkspaceInstance{
+		ID:                 uuid.New(),
+		UsageAttributionID: attributionID,
+		CreationTime:       db.NewVarcharTime(day.Add(10*time.Hour + 5*time.Minute)),
+		StoppedTime:        db.NewVarcharTime(day.Add(10*time.Hour + 8*time.Minute)),
+	})
+	dbtest.CreateWorkspaceInstances(t, conn, instanceA, instanceB)
+
+	price := func(class string, _ db.AttributionID, start, end time.Time) int64 {
+		return int64(end.Sub(start).Minutes())
+	}
+
+	rolluper := NewRolluper(conn, price, nil, time.Minute)
+	rolluper.lastTick = day.Add(10 * time.Hour)
+
+	// Tick 1's window only contains instance A, which just stopped.
+	require.NoError(t, rolluper.tick(context.Background(), day.Add(10*time.Hour+5*time.Minute)))
+	rolluper.lastTick = day.Add(10*time.Hour + 5*time.Minute)
+
+	summary, err := db.CreditSummaryForTeamsFromRollup(context.Background(), conn, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), summary[teamID])
+
+	// Tick 2's window only contains instance B, but it must recompute the
+	// whole day bucket, so the result still includes instance A's credits
+	// rather than overwriting the bucket with only instance B's.
+	require.NoError(t, rolluper.tick(context.Background(), day.Add(10*time.Hour+10*time.Minute)))
+
+	summary, err = db.CreditSummaryForTeamsFromRollup(context.Background(), conn, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, int64(7), summary[teamID])
+}
+
+// TestRolluper_UpsertForInstancesAgreesWithTicker reproduces attaching a
+// Rolluper to both a reconciler (via UpsertForInstances) and its own
+// ticker, against the same bucket: whichever one runs last must not
+// double-count what the other already wrote, since both use the same
+// overwrite-the-whole-bucket semantics.
+func TestRolluper_UpsertForInstancesAgreesWithTicker(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+
+	teamID := uuid.New().String()
+	attributionID := db.NewTeamAttributionID(teamID)
+	day := time.Date(2022, 05, 30, 0, 0, 0, 0, time.UTC)
+
+	instance := dbtest.NewWorkspaceInstance(t, db.WorkspaceInstance{
+		ID:                 uuid.New(),
+		UsageAttributionID: attributionID,
+		CreationTime:       db.NewVarcharTime(day.Add(9 * time.Hour)),
+		StoppedTime:        db.NewVarcharTime(day.Add(10 * time.Hour)),
+	})
+	dbtest.CreateWorkspaceInstances(t, conn, instance)
+
+	price := func(class string, _ db.AttributionID, start, end time.Time) int64 {
+		return int64(end.Sub(start).Minutes())
+	}
+
+	rolluper := NewRolluper(conn, price, nil, time.Minute)
+	rolluper.lastTick = day.Add(9 * time.Hour)
+
+	// The reconciler writes the instance's full credits via UpsertForInstances.
+	require.NoError(t, rolluper.UpsertForInstances(context.Background(), []db.WorkspaceInstance{instance}, day.Add(11*time.Hour)))
+
+	// The ticker then also ticks across a window overlapping the same bucket.
+	require.NoError(t, rolluper.tick(context.Background(), day.Add(11*time.Hour)))
+
+	summary, err := db.CreditSummaryForTeamsFromRollup(context.Background(), conn, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, int64(60), summary[teamID])
+}
+
+// TestRolluper_SplitsAtPriceChangeBoundaries reproduces an instance running
+// across a price change within a single day: without consulting a
+// BoundaryFunc, the whole day would be billed at one rate, diverging from
+// what the authoritative UsageReport would charge for the same instance.
+func TestRolluper_SplitsAtPriceChangeBoundaries(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+
+	teamID := uuid.New().String()
+	attributionID := db.NewTeamAttributionID(teamID)
+	day := time.Date(2022, 05, 30, 0, 0, 0, 0, time.UTC)
+	priceChange := day.Add(10 * time.Hour)
+
+	instance := dbtest.NewWorkspaceInstance(t, db.WorkspaceInstance{
+		ID:                 uuid.New(),
+		UsageAttributionID: attributionID,
+		CreationTime:       db.NewVarcharTime(day.Add(9 * time.Hour)),
+		StoppedTime:        db.NewVarcharTime(day.Add(11 * time.Hour)),
+	})
+	dbtest.CreateWorkspaceInstances(t, conn, instance)
+
+	// 1 credit/minute before the price change, 2 credits/minute after.
+	price := func(class string, _ db.AttributionID, start, end time.Time) int64 {
+		rate := int64(1)
+		if !start.Before(priceChange) {
+			rate = 2
+		}
+		return rate * int64(end.Sub(start).Minutes())
+	}
+	boundaries := func(start, end time.Time) []time.Time {
+		if start.Before(priceChange) && priceChange.Before(end) {
+			return []time.Time{priceChange}
+		}
+		return nil
+	}
+
+	rolluper := NewRolluper(conn, price, boundaries, time.Minute)
+	require.NoError(t, rolluper.UpsertForInstances(context.Background(), []db.WorkspaceInstance{instance}, day.Add(11*time.Hour)))
+
+	summary, err := db.CreditSummaryForTeamsFromRollup(context.Background(), conn, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	// 60 minutes at 1 credit/minute, then 60 minutes at 2 credits/minute.
+	require.Equal(t, int64(60+120), summary[teamID])
+}
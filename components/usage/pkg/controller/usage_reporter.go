@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+	"gorm.io/gorm"
+)
+
+// UsageReporter answers drill-down usage queries for a single attribution
+// ID, for self-hosted installations that need more than the aggregate
+// CreditSummaryForTeams to justify an invoice. Unlike UsageReport, it pushes
+// filtering and pagination into SQL instead of materialising the whole
+// report in memory.
+type UsageReporter struct {
+	conn *gorm.DB
+}
+
+// NewUsageReporter constructs a UsageReporter backed by conn.
+func NewUsageReporter(conn *gorm.DB) *UsageReporter {
+	return &UsageReporter{conn: conn}
+}
+
+// Page returns a single page of instance-level usage entries for
+// cursor.AttributionID, matching cursor's filters.
+//
+// This lives on UsageReporter rather than as a method on UsageReport, since
+// UsageReport is an already-materialised, in-memory slice: paging it would
+// mean loading every instance for an attribution just to discard all but
+// one page. Page instead pushes filtering and pagination into SQL, which
+// needs a live conn, so it belongs on the type that holds one.
+func (r *UsageReporter) Page(ctx context.Context, cursor UsageCursor) (UsageReportPage, error) {
+	limit := cursor.Limit
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	page := cursor.Page
+	if page <= 0 {
+		page = 1
+	}
+
+	var total int64
+	if err := r.usageQuery(ctx, cursor).Count(&total).Error; err != nil {
+		return UsageReportPage{}, fmt.Errorf("failed to count workspace instance usage: %w", err)
+	}
+
+	var entries UsageReport
+	err := r.usageQuery(ctx, cursor).
+		Order("startedAt DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&entries).Error
+	if err != nil {
+		return UsageReportPage{}, fmt.Errorf("failed to page workspace instance usage: %w", err)
+	}
+
+	pageCount := int(total) / limit
+	if int(total)%limit != 0 {
+		pageCount++
+	}
+
+	return UsageReportPage{
+		Results:     entries,
+		PageCount:   pageCount,
+		TotalCount:  total,
+		CurrentPage: page,
+	}, nil
+}
+
+// CreditSummaryForTeams sums the usage rollup table for all days in
+// [since, before), keyed by team ID. It is a much cheaper alternative to
+// materialising a UsageReport and calling UsageReport.CreditSummaryForTeams
+// for callers that only need day-granular totals, e.g. a "this month so
+// far" balance.
+func (r *UsageReporter) CreditSummaryForTeams(ctx context.Context, since, before time.Time) (map[string]int64, error) {
+	return db.CreditSummaryForTeamsFromRollup(ctx, r.conn, since, before)
+}
+
+func (r *UsageReporter) usageQuery(ctx context.Context, cursor UsageCursor) *gorm.DB {
+	query := r.conn.WithContext(ctx).Model(&db.WorkspaceInstanceUsage{}).Where("attributionId = ?", cursor.AttributionID)
+	if cursor.Search != "" {
+		query = query.Where("workspaceId LIKE ?", "%"+cursor.Search+"%")
+	}
+	if !cursor.Since.IsZero() {
+		query = query.Where("startedAt >= ?", cursor.Since)
+	}
+	if !cursor.Before.IsZero() {
+		query = query.Where("startedAt < ?", cursor.Before)
+	}
+	return query
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package db
+
+import "strings"
+
+// AttributionID identifies the entity a workspace instance's usage is billed
+// against. It is persisted as "<entity>:<id>", e.g. "team:<uuid>" or
+// "user:<uuid>".
+type AttributionID string
+
+const (
+	AttributionEntityTeam = "team"
+	AttributionEntityUser = "user"
+)
+
+// NewTeamAttributionID returns the AttributionID for a team.
+func NewTeamAttributionID(teamID string) AttributionID {
+	return AttributionID(AttributionEntityTeam + ":" + teamID)
+}
+
+// NewUserAttributionID returns the AttributionID for a user.
+func NewUserAttributionID(userID string) AttributionID {
+	return AttributionID(AttributionEntityUser + ":" + userID)
+}
+
+// Values splits the AttributionID into its entity and identifier parts.
+func (a AttributionID) Values() (entity string, id string) {
+	parts := strings.SplitN(string(a), ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// IsEntity returns true if the AttributionID identifies the given entity kind.
+func (a AttributionID) IsEntity(entity string) bool {
+	e, _ := a.Values()
+	return e == entity
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package dbtest
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	envUsageDBHost     = "DB_HOST"
+	envUsageDBPort     = "DB_PORT"
+	envUsageDBUser     = "DB_USERNAME"
+	envUsageDBPassword = "DB_PASSWORD"
+	envUsageDBName     = "DB_NAME"
+)
+
+// ConnectForTests opens a connection to a database usable in tests,
+// configured through the same environment variables production deployments
+// use. Tests are skipped if no database is reachable.
+func ConnectForTests(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	host := envOrDefault(envUsageDBHost, "127.0.0.1")
+	port := envOrDefault(envUsageDBPort, "3306")
+	user := envOrDefault(envUsageDBUser, "root")
+	password := os.Getenv(envUsageDBPassword)
+	name := envOrDefault(envUsageDBName, "gitpod")
+
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC", user, password, host, port, name)
+	conn, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Skipf("cannot connect to database, skipping: %v", err)
+	}
+
+	t.Cleanup(func() {
+		sqlDB, err := conn.DB()
+		require.NoError(t, err)
+		_ = sqlDB.Close()
+	})
+
+	return conn
+}
+
+func envOrDefault(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
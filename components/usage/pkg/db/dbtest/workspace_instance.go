@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package dbtest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// NewWorkspaceInstance fills in sensible defaults for any fields of
+// instance that the caller left unset, so that tests only need to specify
+// the fields relevant to the scenario under test.
+func NewWorkspaceInstance(t *testing.T, instance db.WorkspaceInstance) db.WorkspaceInstance {
+	t.Helper()
+
+	if instance.ID == uuid.Nil {
+		instance.ID = uuid.New()
+	}
+	if instance.WorkspaceID == "" {
+		instance.WorkspaceID = GenerateWorkspaceID()
+	}
+	if instance.OwnerID == uuid.Nil {
+		instance.OwnerID = uuid.New()
+	}
+	if instance.WorkspaceClass == "" {
+		instance.WorkspaceClass = "default"
+	}
+	if instance.Type == "" {
+		instance.Type = db.WorkspaceType_Regular
+	}
+	if instance.UsageAttributionID == "" {
+		instance.UsageAttributionID = db.NewTeamAttributionID(uuid.New().String())
+	}
+
+	return instance
+}
+
+// CreateWorkspaceInstances persists instances to conn, for use by tests that
+// exercise queries against real data.
+func CreateWorkspaceInstances(t *testing.T, conn *gorm.DB, instances ...db.WorkspaceInstance) {
+	t.Helper()
+
+	if len(instances) == 0 {
+		return
+	}
+
+	require.NoError(t, conn.Create(&instances).Error)
+
+	t.Cleanup(func() {
+		for _, instance := range instances {
+			require.NoError(t, conn.Where("id = ?", instance.ID).Delete(&db.WorkspaceInstance{}).Error)
+		}
+	})
+}
+
+// GenerateWorkspaceID generates a short, random workspace ID for tests, of
+// the form "test-workspace-abc123de".
+func GenerateWorkspaceID() string {
+	return fmt.Sprintf("test-workspace-%s", uuid.New().String()[:8])
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+// Package dbtime is the single seam for time handling across the usage
+// module: it normalises every timestamp to UTC, truncated to microseconds to
+// match MySQL's DATETIME(6) columns, and converts to and from the VARCHAR
+// encoding db.VarcharTime persists with.
+package dbtime
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+)
+
+var nowFunc = time.Now
+
+// Now returns the current time, normalised as Time does. Production code
+// should call Now() instead of time.Now() so that tests can override it
+// with SetNowFunc.
+func Now() time.Time {
+	return Time(nowFunc())
+}
+
+// Time normalises t to UTC, truncated to microsecond precision, matching the
+// precision of the `DATETIME(6)` columns timestamps are persisted in.
+func Time(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// SetNowFunc overrides the function Now uses to obtain the current time.
+// Tests should restore the original with Reset once done.
+func SetNowFunc(f func() time.Time) {
+	nowFunc = f
+}
+
+// Reset restores Now to return the actual current time.
+func Reset() {
+	nowFunc = time.Now
+}
+
+// ToVarchar converts t into the VARCHAR-backed representation workspace
+// instance timestamps are persisted with.
+func ToVarchar(t time.Time) db.VarcharTime {
+	return db.NewVarcharTime(Time(t))
+}
+
+// FromVarchar converts v back into a normalised time.Time. The zero
+// time.Time is returned if v is not set.
+func FromVarchar(v db.VarcharTime) time.Time {
+	return Time(v.Time())
+}
+
+// NullTimeFromVarchar converts v into a sql.NullTime, valid only if v is
+// set.
+func NullTimeFromVarchar(v db.VarcharTime) sql.NullTime {
+	if !v.IsSet() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: FromVarchar(v), Valid: true}
+}
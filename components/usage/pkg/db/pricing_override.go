@@ -0,0 +1,41 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// PricingOverride is a team-specific credits-per-minute rate for a
+// workspace class, e.g. for an enterprise negotiated rate, that takes
+// precedence over the standard pricing.
+type PricingOverride struct {
+	TeamID           string  `gorm:"column:teamId;type:char;size:36;primaryKey;"`
+	WorkspaceClass   string  `gorm:"column:workspaceClass;type:varchar;size:255;primaryKey;"`
+	CreditsPerMinute float64 `gorm:"column:creditsPerMinute;type:double;"`
+}
+
+// TableName specifies the database table name for Gorm to use.
+func (p *PricingOverride) TableName() string {
+	return "pricing_overrides"
+}
+
+// FindPricingOverride looks up the PricingOverride for teamID and
+// workspaceClass. ok is false if no override exists.
+func FindPricingOverride(ctx context.Context, conn *gorm.DB, teamID, workspaceClass string) (override PricingOverride, ok bool, err error) {
+	err = conn.WithContext(ctx).
+		Where("teamId = ? AND workspaceClass = ?", teamID, workspaceClass).
+		First(&override).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return PricingOverride{}, false, nil
+	}
+	if err != nil {
+		return PricingOverride{}, false, err
+	}
+	return override, true, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package db
+
+import "time"
+
+// varcharTimeLayout matches the format workspace instance timestamps are
+// persisted with in the `db` database (a VARCHAR column, not a native
+// DATETIME), e.g. "2022-05-30T00:00:00.000Z".
+const varcharTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// VarcharTime wraps a time.Time that is persisted as a VARCHAR rather than a
+// native SQL timestamp. The zero value represents "not set".
+type VarcharTime struct {
+	t     time.Time
+	valid bool
+}
+
+// NewVarcharTime constructs a VarcharTime from t.
+func NewVarcharTime(t time.Time) VarcharTime {
+	return VarcharTime{t: t, valid: true}
+}
+
+// Time returns the underlying time.Time. It is the zero time.Time if the
+// VarcharTime is not set.
+func (v VarcharTime) Time() time.Time {
+	return v.t
+}
+
+// IsSet returns true if the VarcharTime has been assigned a value.
+func (v VarcharTime) IsSet() bool {
+	return v.valid
+}
+
+// String renders the VarcharTime in the format it is persisted with.
+func (v VarcharTime) String() string {
+	if !v.valid {
+		return ""
+	}
+	return v.t.UTC().Format(varcharTimeLayout)
+}
+
+// ParseVarcharTime parses a VarcharTime from its persisted string form.
+func ParseVarcharTime(s string) (VarcharTime, error) {
+	if s == "" {
+		return VarcharTime{}, nil
+	}
+	t, err := time.Parse(varcharTimeLayout, s)
+	if err != nil {
+		return VarcharTime{}, err
+	}
+	return NewVarcharTime(t), nil
+}
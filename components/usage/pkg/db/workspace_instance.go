@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WorkspaceType distinguishes regular, user-facing workspaces from prebuilds.
+type WorkspaceType string
+
+const (
+	WorkspaceType_Regular  WorkspaceType = "regular"
+	WorkspaceType_Prebuild WorkspaceType = "prebuild"
+)
+
+// WorkspaceInstance mirrors the relevant columns of the `d_b_workspace_instance`
+// table. Only the columns the usage reconciler cares about are modelled here.
+type WorkspaceInstance struct {
+	ID                 uuid.UUID      `gorm:"column:id;type:char;size:36;"`
+	WorkspaceID        string         `gorm:"column:workspaceId;type:char;size:36;"`
+	OwnerID            uuid.UUID      `gorm:"column:ownerId;type:char;size:36;"`
+	ProjectID          sql.NullString `gorm:"column:projectId;type:varchar;size:255;"`
+	WorkspaceClass     string         `gorm:"column:workspaceClass;type:varchar;size:255;"`
+	Type               WorkspaceType  `gorm:"column:type;type:varchar;size:255;"`
+	UsageAttributionID AttributionID  `gorm:"column:usageAttributionId;type:varchar;size:255;"`
+	CreationTime       VarcharTime    `gorm:"column:creationTime;type:varchar;size:255;"`
+	StartedTime        VarcharTime    `gorm:"column:startedTime;type:varchar;size:255;"`
+	StoppedTime        VarcharTime    `gorm:"column:stoppedTime;type:varchar;size:255;"`
+}
+
+// TableName specifies the database table name for Gorm to use.
+func (w *WorkspaceInstance) TableName() string {
+	return "d_b_workspace_instance"
+}
+
+// WorkspaceInstanceForUsage is the subset of WorkspaceInstance columns
+// required to compute usage for a reconciliation run.
+type WorkspaceInstanceForUsage struct {
+	ID                 uuid.UUID
+	WorkspaceID        string
+	OwnerID            uuid.UUID
+	ProjectID          sql.NullString
+	WorkspaceClass     string
+	Type               WorkspaceType
+	UsageAttributionID AttributionID
+	CreationTime       VarcharTime
+	StoppedTime        VarcharTime
+}
+
+// WorkspaceInstanceUsage is a single line item of billable usage derived from
+// a WorkspaceInstanceForUsage, persisted to `usage/pkg/db`'s
+// `workspace_instance_usage` table.
+type WorkspaceInstanceUsage struct {
+	InstanceID     uuid.UUID     `gorm:"column:instanceId;type:char;size:36;"`
+	AttributionID  AttributionID `gorm:"column:attributionId;type:varchar;size:255;"`
+	UserID         uuid.UUID     `gorm:"column:userId;type:char;size:36;"`
+	WorkspaceID    string        `gorm:"column:workspaceId;type:char;size:36;"`
+	ProjectID      string        `gorm:"column:projectId;type:varchar;size:255;"`
+	WorkspaceType  WorkspaceType `gorm:"column:workspaceType;type:varchar;size:255;"`
+	WorkspaceClass string        `gorm:"column:workspaceClass;type:varchar;size:255;"`
+	CreditsUsed    int64         `gorm:"column:creditsUsed;type:bigint;"`
+	StartedAt      time.Time     `gorm:"column:startedAt;"`
+	StoppedAt      sql.NullTime  `gorm:"column:stoppedAt;"`
+	GenerationID   int           `gorm:"column:generationId;type:int;"`
+	Deleted        bool          `gorm:"column:deleted;type:boolean;"`
+}
+
+// TableName specifies the database table name for Gorm to use.
+func (w *WorkspaceInstanceUsage) TableName() string {
+	return "workspace_instance_usage"
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FindWorkspaceInstancesForUsageInRange loads all workspace instances whose
+// usage overlaps [start, end): those that were created before end and stopped at
+// or after start, plus any still running. It is shared by the reconciler
+// and the rollup service, which both need to know which instances' usage
+// changed in a given window.
+//
+// The upper bound on stoppedTime is deliberately left uncapped at end: an
+// instance that stopped after the window still overlapped it and must be
+// returned, even though only the portion of its usage up to end is billable
+// for this window. Capping its billed time at end is the caller's job (see
+// instancesToUsageRecords' maxStopTime), not this query's.
+func FindWorkspaceInstancesForUsageInRange(ctx context.Context, conn *gorm.DB, start, end time.Time) ([]WorkspaceInstance, error) {
+	startVarchar := NewVarcharTime(start).String()
+	endVarchar := NewVarcharTime(end).String()
+
+	var instances []WorkspaceInstance
+	tx := conn.WithContext(ctx).
+		Where("stoppedTime != '' AND stoppedTime >= ? AND creationTime < ?", startVarchar, endVarchar).
+		Or("startedTime != '' AND stoppedTime = ''").
+		Find(&instances)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return instances, nil
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package db_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gitpod-io/gitpod/usage/pkg/db"
+	"github.com/gitpod-io/gitpod/usage/pkg/db/dbtest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFindWorkspaceInstancesForUsageInRange_StraddlesWindowEnd reproduces an
+// instance that started within [start, end) but stopped after end: it still
+// overlapped the window and must be returned, even though its full credits
+// aren't billable to this window alone.
+func TestFindWorkspaceInstancesForUsageInRange_StraddlesWindowEnd(t *testing.T) {
+	conn := dbtest.ConnectForTests(t)
+
+	start := time.Date(2022, 05, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2022, 06, 1, 0, 0, 0, 0, time.UTC)
+
+	straddling := dbtest.NewWorkspaceInstance(t, db.WorkspaceInstance{
+		ID:           uuid.New(),
+		CreationTime: db.NewVarcharTime(start),
+		StartedTime:  db.NewVarcharTime(start),
+		StoppedTime:  db.NewVarcharTime(end.Add(time.Hour)),
+	})
+	dbtest.CreateWorkspaceInstances(t, conn, straddling)
+
+	instances, err := db.FindWorkspaceInstancesForUsageInRange(context.Background(), conn, start, end)
+	require.NoError(t, err)
+
+	var found bool
+	for _, instance := range instances {
+		if instance.ID == straddling.ID {
+			found = true
+		}
+	}
+	require.True(t, found, "instance stopping after the window's end must still be returned, as it overlapped the window")
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Gitpod GmbH. All rights reserved.
+// Licensed under the GNU Affero General Public License (AGPL).
+// See License-AGPL.txt in the project root for license information.
+
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WorkspaceInstanceUsageRollup holds the pre-aggregated credits used by a
+// team, for a given day and workspace class. It is kept up to date
+// incrementally by the rollup service, so that "today's" usage can be
+// queried without scanning all of workspace_instance_usage.
+type WorkspaceInstanceUsageRollup struct {
+	AttributionID  AttributionID `gorm:"column:attributionId;type:varchar;size:255;primaryKey;"`
+	Day            time.Time     `gorm:"column:day;primaryKey;"`
+	WorkspaceClass string        `gorm:"column:workspaceClass;type:varchar;size:255;primaryKey;"`
+	CreditsUsed    int64         `gorm:"column:creditsUsed;type:bigint;"`
+}
+
+// TableName specifies the database table name for Gorm to use.
+func (w *WorkspaceInstanceUsageRollup) TableName() string {
+	return "workspace_instance_usage_rollup"
+}
+
+// UpsertWorkspaceInstanceUsageRollup inserts rollup, or, if a row already
+// exists for its (attributionId, day, workspaceClass), overwrites its
+// creditsUsed. Callers are expected to have already summed all credits for
+// the bucket, as this is not an additive upsert.
+func UpsertWorkspaceInstanceUsageRollup(ctx context.Context, conn *gorm.DB, rollup WorkspaceInstanceUsageRollup) error {
+	return conn.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "attributionId"}, {Name: "day"}, {Name: "workspaceClass"}},
+			DoUpdates: clause.AssignmentColumns([]string{"creditsUsed"}),
+		}).
+		Create(&rollup).Error
+}
+
+// CreditSummaryForTeamsFromRollup sums the rollup table for all days in
+// [start, end), keyed by team ID. It is a much cheaper alternative to
+// UsageReport.CreditSummaryForTeams for queries that only need "since day X"
+// granularity.
+func CreditSummaryForTeamsFromRollup(ctx context.Context, conn *gorm.DB, start, end time.Time) (map[string]int64, error) {
+	var rows []struct {
+		AttributionID AttributionID
+		CreditsUsed   int64
+	}
+	err := conn.WithContext(ctx).
+		Model(&WorkspaceInstanceUsageRollup{}).
+		Select("attributionId AS attribution_id, SUM(creditsUsed) AS credits_used").
+		Where("day >= ? AND day < ?", start, end).
+		Group("attributionId").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary := map[string]int64{}
+	for _, row := range rows {
+		if !row.AttributionID.IsEntity(AttributionEntityTeam) {
+			continue
+		}
+		_, teamID := row.AttributionID.Values()
+		summary[teamID] += row.CreditsUsed
+	}
+	return summary, nil
+}